@@ -0,0 +1,151 @@
+package main
+
+import (
+	"errors"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// PaymentState is a node in the payment state machine tracked by the
+// ControlTower: Queued -> InFlight -> Succeeded | Failed.
+type PaymentState string
+
+const (
+	StateQueued    PaymentState = "queued"
+	StateInFlight  PaymentState = "in_flight"
+	StateSucceeded PaymentState = "succeeded"
+	StateFailed    PaymentState = "failed"
+)
+
+var (
+	ErrPaymentAlreadyExists = errors.New("payment already succeeded")
+	ErrPaymentInFlight      = errors.New("payment is already being attempted")
+)
+
+// resubmitFailedQuery transitions a correlationId's payment_attempts row
+// back to Queued, but only if it's still the one read by InitPayment
+// (state = Failed); the RowsAffected check in InitPayment catches the case
+// where a concurrent caller won that race.
+const resubmitFailedQuery = `
+	update payment_attempts
+	set state = $1, amount = $2, attempt_num = 0, last_error = null, updated_at = now()
+	where correlation_id = $3 and state = $4
+`
+
+// ControlTower persists every payment through an explicit state machine,
+// keyed by CorrelationID, so restarts and fallback retries never double-charge
+// the upstream processors.
+type ControlTower struct {
+	db *sqlx.DB
+}
+
+func newControlTower(db *sqlx.DB) *ControlTower {
+	db.Exec(`
+		create table if not exists payment_attempts (
+			correlation_id text primary key,
+			amount real,
+			state text not null,
+			processor text,
+			attempt_num int not null default 0,
+			last_error text,
+			created_at timestamp not null default now(),
+			updated_at timestamp not null default now()
+		)
+	`)
+	return &ControlTower{db: db}
+}
+
+// InitPayment records a new payment as Queued, rejecting it if it has already
+// succeeded or is currently being attempted. The insert is the atomic guard:
+// concurrent callers for the same corrID race on "do nothing", and only the
+// one that actually inserted the row gets to proceed.
+func (ct *ControlTower) InitPayment(corrID string, amount float32) error {
+	res, err := ct.db.Exec(`
+		insert into payment_attempts (correlation_id, amount, state)
+		values ($1, $2, $3)
+		on conflict (correlation_id) do nothing
+	`, corrID, amount, StateQueued)
+	if err != nil {
+		return err
+	}
+
+	inserted, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if inserted > 0 {
+		return nil
+	}
+
+	var state string
+	if err := ct.db.Get(&state, "select state from payment_attempts where correlation_id = $1", corrID); err != nil {
+		return err
+	}
+
+	switch PaymentState(state) {
+	case StateSucceeded:
+		return ErrPaymentAlreadyExists
+	case StateFailed:
+		// The retrier gave up on this correlationId; a resubmission deserves
+		// a fresh attempt rather than being stuck behind ErrPaymentInFlight
+		// forever.
+		res, err := ct.db.Exec(resubmitFailedQuery, StateQueued, amount, corrID, StateFailed)
+		if err != nil {
+			return err
+		}
+		resubmitted, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if resubmitted == 0 {
+			// Lost the race to another concurrent resubmission.
+			return ErrPaymentInFlight
+		}
+		return nil
+	default:
+		return ErrPaymentInFlight
+	}
+}
+
+// RegisterAttempt marks a payment as InFlight against a given processor.
+func (ct *ControlTower) RegisterAttempt(corrID, processor string, attemptNum int) error {
+	_, err := ct.db.Exec(`
+		update payment_attempts
+		set state = $1, processor = $2, attempt_num = $3, updated_at = now()
+		where correlation_id = $4
+	`, StateInFlight, processor, attemptNum, corrID)
+	return err
+}
+
+// Success marks a payment as Succeeded against the processor that accepted it.
+func (ct *ControlTower) Success(corrID, processor string, requestedAt time.Time) error {
+	_, err := ct.db.Exec(`
+		update payment_attempts
+		set state = $1, processor = $2, updated_at = now()
+		where correlation_id = $3
+	`, StateSucceeded, processor, corrID)
+	return err
+}
+
+// Fail marks a payment as terminally Failed with the given reason.
+func (ct *ControlTower) Fail(corrID, reason string) error {
+	_, err := ct.db.Exec(`
+		update payment_attempts
+		set state = $1, last_error = $2, updated_at = now()
+		where correlation_id = $3
+	`, StateFailed, reason, corrID)
+	return err
+}
+
+// RecoverPending returns every payment left Queued or InFlight by a previous
+// run, so the worker pool can re-enqueue them on startup.
+func (ct *ControlTower) RecoverPending() ([]Payment, error) {
+	var rows []Payment
+	err := ct.db.Select(&rows, `
+		select correlation_id, amount
+		from payment_attempts
+		where state in ($1, $2)
+	`, StateQueued, StateInFlight)
+	return rows, err
+}
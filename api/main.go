@@ -2,17 +2,26 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/jmoiron/sqlx"
 	_ "github.com/lib/pq"
 )
 
+const (
+	sinkBatchSize     = 500
+	sinkFlushInterval = 50 * time.Millisecond
+)
+
 const (
 	host         = "database"
 	port         = 5432
@@ -22,9 +31,24 @@ const (
 )
 
 var db *sqlx.DB
+var controlTower *ControlTower
+var retrier *Retrier
+var processorHealth *ProcessorHealth
+var paymentHub *PaymentHub
+var paymentSink *PaymentSink
 
 var paymentsQueue = make(chan Payment, 10000)
 
+// shuttingDown is closed the moment shutdown begins, so any postPayment
+// handler blocked trying to enqueue into a full paymentsQueue bails out
+// immediately instead of potentially still being mid-send when
+// paymentsQueue is closed.
+var shuttingDown = make(chan struct{})
+
+// inFlightHandlers tracks postPayment calls that haven't returned yet, so the
+// shutdown goroutine can wait for all of them before closing paymentsQueue.
+var inFlightHandlers sync.WaitGroup
+
 type Payment struct {
 	CorrelationID string  `json:"correlationId" db:"correlation_id"`
 	Amount        float32 `json:"amount" db:"amount"`
@@ -56,8 +80,20 @@ func main() {
 	log.Println("starting")
 	http.HandleFunc("POST /payments", postPayment)
 	http.HandleFunc("GET /payments-summary", getPaymentsSummary)
+	http.HandleFunc("GET /health", getHealth)
+	http.HandleFunc("GET /ws/payments", handlePaymentsWebSocket)
 
 	connectToDatabase()
+	processorHealth = newProcessorHealth()
+
+	pending, err := controlTower.RecoverPending()
+	if err != nil {
+		log.Println(err)
+	}
+	for _, payment := range pending {
+		paymentsQueue <- payment
+	}
+
 	// TODO: I think that I'm processing my payments synchronously, I will need to made this in parallel way
 	// go paymentWorker()
 	numWorkers := 10      // Define how many workers you want
@@ -69,8 +105,67 @@ func main() {
 		go paymentWorker(i, &wg)
 	}
 
+	retrierCtx, cancelRetrier := context.WithCancel(context.Background())
+	sinkCtx, cancelSink := context.WithCancel(context.Background())
+	var retrierWG, sinkWG sync.WaitGroup
+	retrierWG.Add(1)
+	go func() {
+		defer retrierWG.Done()
+		retrier.Run(retrierCtx)
+	}()
+	sinkWG.Add(1)
+	go func() {
+		defer sinkWG.Done()
+		paymentSink.Run(sinkCtx)
+	}()
+
+	srv := &http.Server{Addr: ":9999"}
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigCh
+		log.Println("shutting down")
+
+		// Signal in-flight and newly-blocked postPayment handlers first: a
+		// handler stuck on paymentsQueue <- payment (a full queue) would
+		// otherwise still be running when close(paymentsQueue) below
+		// executes, and that send would panic.
+		close(shuttingDown)
+
+		shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer shutdownCancel()
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			log.Println(err)
+		}
+
+		// Every postPayment call has either finished its enqueue or taken the
+		// shuttingDown branch by now; safe to close paymentsQueue once this
+		// returns.
+		inFlightHandlers.Wait()
+
+		// Stop the retrier from requeueing more work before closing the
+		// queue it requeues into; any row it hasn't gotten to stays in
+		// payment_retries and is picked up again on the next startup.
+		cancelRetrier()
+		retrierWG.Wait()
+
+		close(paymentsQueue)
+		wg.Wait() // let every worker drain what's left and exit
+
+		// Only now is it safe to tell the sink to flush and exit: nothing
+		// enqueues into it anymore.
+		cancelSink()
+		sinkWG.Wait()
+
+		log.Println("shutdown complete")
+		os.Exit(0)
+	}()
+
 	log.Println("Server is up.1")
-	http.ListenAndServe(":9999", nil)
+	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		log.Println(err)
+	}
 }
 
 func connectToDatabase() {
@@ -103,12 +198,53 @@ func connectToDatabase() {
 		alter table payments add column if not exists processor boolean
 	`)
 	fmt.Println("Successfully connected to database!")
+
+	controlTower = newControlTower(db)
+	paymentHub = newPaymentHub()
+	retrier = newRetrier(db, controlTower, paymentsQueue, paymentHub)
+	paymentSink = newPaymentSink(db, sinkBatchSize, sinkFlushInterval)
 }
 
 func postPayment(w http.ResponseWriter, r *http.Request) {
+	inFlightHandlers.Add(1)
+	defer inFlightHandlers.Done()
+
 	var payment Payment
 	json.NewDecoder(r.Body).Decode(&payment)
-	paymentsQueue <- payment
+
+	if err := controlTower.InitPayment(payment.CorrelationID, payment.Amount); err != nil {
+		switch err {
+		case ErrPaymentAlreadyExists, ErrPaymentInFlight:
+			w.WriteHeader(http.StatusConflict)
+		default:
+			log.Println(err)
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		return
+	}
+
+	paymentHub.Publish(PaymentEvent{
+		Type:          eventQueued,
+		CorrelationID: payment.CorrelationID,
+		Timestamp:     time.Now(),
+	})
+
+	select {
+	case paymentsQueue <- payment:
+	case <-shuttingDown:
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+}
+
+// attemptError reports why a processor attempt is being treated as a
+// failure, so payment_retries.last_error carries the real cause (a
+// connection/timeout error, or the non-200 status the processor returned)
+// instead of a generic message.
+func attemptError(err error, res *http.Response) error {
+	if err != nil {
+		return err
+	}
+	return fmt.Errorf("processor responded with status %d", res.StatusCode)
 }
 
 func paymentWorker(workerID int, wg *sync.WaitGroup) {
@@ -117,31 +253,68 @@ func paymentWorker(workerID int, wg *sync.WaitGroup) {
 	for payment := range paymentsQueue {
 		payment.RequestedAt = time.Now().Format(time.RFC3339)
 		// log.Println(payment)
+		url, isFallback := processorHealth.SelectProcessor()
+		processor := "default"
+		if isFallback {
+			processor = "fallback"
+		}
+
+		controlTower.RegisterAttempt(payment.CorrelationID, processor, 1)
+		paymentHub.Publish(PaymentEvent{
+			Type:          eventProcessing,
+			CorrelationID: payment.CorrelationID,
+			Processor:     processor,
+			Timestamp:     time.Now(),
+			AttemptCount:  1,
+		})
 		json, _ := json.Marshal(payment)
-		body := bytes.NewBuffer(json)
-		res, _ := http.Post("http://payment-processor-default:8080/payments", "application/json", body)
+		res, err := http.Post(url+"/payments", "application/json", bytes.NewBuffer(json))
+		processorHealth.RecordResult(processor, err == nil && res.StatusCode == http.StatusOK)
 		// log.Println(res.StatusCode)
-		if res.StatusCode != http.StatusOK {
-			log.Println(res.StatusCode)
-			log.Println("fallback")
-			res, _ := http.Post("http://payment-processor-fallback:8080/payments", "application/json", bytes.NewBuffer(json))
-			if res.StatusCode != http.StatusOK {
-				log.Println(res.StatusCode)
-				log.Println("return to queue")
-				paymentsQueue <- payment
-				return
+		if err != nil || res.StatusCode != http.StatusOK {
+			if isFallback {
+				log.Println("scheduling retry")
+				retrier.Schedule(payment, attemptError(err, res))
+				continue
 			}
-			_, err := db.NamedExec("insert into payments (correlation_id, amount, processor, requested_at) values (:correlation_id, :amount, true, :requested_at)", payment)
-			if err != nil {
-				log.Println(err)
+
+			log.Println("fallback")
+			controlTower.RegisterAttempt(payment.CorrelationID, "fallback", 2)
+			paymentHub.Publish(PaymentEvent{
+				Type:          eventProcessing,
+				CorrelationID: payment.CorrelationID,
+				Processor:     "fallback",
+				Timestamp:     time.Now(),
+				AttemptCount:  2,
+			})
+			res, err := http.Post("http://payment-processor-fallback:8080/payments", "application/json", bytes.NewBuffer(json))
+			processorHealth.RecordResult("fallback", err == nil && res.StatusCode == http.StatusOK)
+			if err != nil || res.StatusCode != http.StatusOK {
+				log.Println("scheduling retry")
+				retrier.Schedule(payment, attemptError(err, res))
+				continue
 			}
-			return
+			paymentSink.Enqueue(payment.CorrelationID, payment.Amount, true, payment.RequestedAt)
+			controlTower.Success(payment.CorrelationID, "fallback", time.Now())
+			paymentHub.Publish(PaymentEvent{
+				Type:          eventSucceeded,
+				CorrelationID: payment.CorrelationID,
+				Processor:     "fallback",
+				Timestamp:     time.Now(),
+				AttemptCount:  2,
+			})
+			continue
 		}
 		// log.Println("insert")
-		_, err := db.NamedExec("insert into payments (correlation_id, amount, processor, requested_at) values (:correlation_id, :amount, false, :requested_at)", payment)
-		if err != nil {
-			log.Println(err)
-		}
+		paymentSink.Enqueue(payment.CorrelationID, payment.Amount, isFallback, payment.RequestedAt)
+		controlTower.Success(payment.CorrelationID, processor, time.Now())
+		paymentHub.Publish(PaymentEvent{
+			Type:          eventSucceeded,
+			CorrelationID: payment.CorrelationID,
+			Processor:     processor,
+			Timestamp:     time.Now(),
+			AttemptCount:  1,
+		})
 	}
 }
 
@@ -158,6 +331,11 @@ func paymentWorker(workerID int, wg *sync.WaitGroup) {
 // 	fmt.Fprintf(w, "%s", string(json))
 // }
 
+func getHealth(w http.ResponseWriter, r *http.Request) {
+	json, _ := json.MarshalIndent(processorHealth.Status(), "", "  ")
+	fmt.Fprintf(w, "%s", string(json))
+}
+
 func getPaymentsSummary(w http.ResponseWriter, r *http.Request) {
 	from := r.URL.Query().Get("from")
 	to := r.URL.Query().Get("to")
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// PaymentEvent is published every time a payment moves through the control
+// tower's state machine.
+type PaymentEvent struct {
+	Type          string    `json:"type"`
+	CorrelationID string    `json:"correlationId"`
+	Processor     string    `json:"processor,omitempty"`
+	Timestamp     time.Time `json:"timestamp"`
+	AttemptCount  int       `json:"attemptCount"`
+}
+
+const (
+	eventQueued     = "queued"
+	eventProcessing = "processing"
+	eventSucceeded  = "succeeded"
+	eventFailed     = "failed"
+)
+
+const (
+	subscriberBufferSize  = 32
+	subscriberWriteWindow = 5 * time.Second
+)
+
+var wsUpgrader = websocket.Upgrader{
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// subscriberConn decouples a websocket connection's writes from whoever is
+// publishing events: Publish only ever does a non-blocking send into
+// events, while a dedicated goroutine drains it and does the actual
+// (deadline-bounded) conn.WriteJSON. A slow or stalled client can only ever
+// stall its own writeLoop, never the publisher.
+type subscriberConn struct {
+	conn   *websocket.Conn
+	events chan PaymentEvent
+	done   chan struct{}
+	once   sync.Once
+}
+
+func newSubscriberConn(conn *websocket.Conn) *subscriberConn {
+	sc := &subscriberConn{
+		conn:   conn,
+		events: make(chan PaymentEvent, subscriberBufferSize),
+		done:   make(chan struct{}),
+	}
+	go sc.writeLoop()
+	return sc
+}
+
+func (sc *subscriberConn) writeLoop() {
+	for {
+		select {
+		case <-sc.done:
+			return
+		case event := <-sc.events:
+			sc.conn.SetWriteDeadline(time.Now().Add(subscriberWriteWindow))
+			if err := sc.conn.WriteJSON(event); err != nil {
+				log.Println(err)
+				sc.conn.Close()
+				return
+			}
+		}
+	}
+}
+
+// deliver queues event for this subscriber without blocking; a subscriber
+// whose buffer is full (a stalled client) drops the event instead of
+// backing up the publisher.
+func (sc *subscriberConn) deliver(event PaymentEvent) {
+	select {
+	case sc.events <- event:
+	default:
+		log.Println("dropping payment event for slow websocket subscriber")
+	}
+}
+
+func (sc *subscriberConn) close() {
+	sc.once.Do(func() { close(sc.done) })
+}
+
+// PaymentHub is a small pub/sub hub: callers subscribe to a specific
+// CorrelationID or to every event, and paymentWorker/retrier publish to it on
+// every state transition.
+type PaymentHub struct {
+	mu          sync.Mutex
+	subscribers map[string][]*subscriberConn
+	wildcard    []*subscriberConn
+}
+
+func newPaymentHub() *PaymentHub {
+	return &PaymentHub{subscribers: make(map[string][]*subscriberConn)}
+}
+
+func (h *PaymentHub) Subscribe(corrID string, sc *subscriberConn) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.subscribers[corrID] = append(h.subscribers[corrID], sc)
+}
+
+func (h *PaymentHub) SubscribeAll(sc *subscriberConn) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.wildcard = append(h.wildcard, sc)
+}
+
+func (h *PaymentHub) Unsubscribe(sc *subscriberConn) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for corrID, subs := range h.subscribers {
+		remaining := removeSubscriber(subs, sc)
+		if len(remaining) == 0 {
+			delete(h.subscribers, corrID)
+		} else {
+			h.subscribers[corrID] = remaining
+		}
+	}
+	h.wildcard = removeSubscriber(h.wildcard, sc)
+}
+
+func removeSubscriber(subs []*subscriberConn, target *subscriberConn) []*subscriberConn {
+	var remaining []*subscriberConn
+	for _, sub := range subs {
+		if sub != target {
+			remaining = append(remaining, sub)
+		}
+	}
+	return remaining
+}
+
+// Publish fans an event out to every subscriber of its CorrelationID and to
+// every wildcard subscriber. It only ever holds h.mu long enough to snapshot
+// the subscriber lists, then hands off to each subscriber's own buffered
+// channel, so a stalled client can't block payment processing.
+func (h *PaymentHub) Publish(event PaymentEvent) {
+	h.mu.Lock()
+	subs := make([]*subscriberConn, 0, len(h.subscribers[event.CorrelationID])+len(h.wildcard))
+	subs = append(subs, h.subscribers[event.CorrelationID]...)
+	subs = append(subs, h.wildcard...)
+	h.mu.Unlock()
+
+	for _, sc := range subs {
+		sc.deliver(event)
+	}
+}
+
+// handlePaymentsWebSocket upgrades to a WebSocket and keeps reading
+// {"subscribe": "<correlationId>"} messages until the client disconnects. An
+// empty or missing correlationId subscribes to every event.
+func handlePaymentsWebSocket(w http.ResponseWriter, r *http.Request) {
+	conn, err := wsUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	sc := newSubscriberConn(conn)
+	defer func() {
+		paymentHub.Unsubscribe(sc)
+		sc.close()
+		conn.Close()
+	}()
+
+	for {
+		var msg struct {
+			Subscribe string `json:"subscribe"`
+		}
+		if err := conn.ReadJSON(&msg); err != nil {
+			return
+		}
+		if msg.Subscribe == "" {
+			paymentHub.SubscribeAll(sc)
+		} else {
+			paymentHub.Subscribe(msg.Subscribe, sc)
+		}
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	breakerFailureThreshold = 5
+	breakerCooldown         = 5 * time.Second
+	probeInterval           = 5 * time.Second
+)
+
+// CircuitState is one of the three states of a classic circuit breaker.
+type CircuitState int
+
+const (
+	CircuitClosed CircuitState = iota
+	CircuitOpen
+	CircuitHalfOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half_open"
+	default:
+		return "closed"
+	}
+}
+
+// circuitBreaker opens after failureThreshold consecutive failures, cools
+// down for a fixed window, then admits a single trial request before
+// closing again.
+type circuitBreaker struct {
+	mu sync.Mutex
+
+	state            CircuitState
+	failureCount     int
+	failureThreshold int
+	cooldown         time.Duration
+	openedAt         time.Time
+	trialInFlight    bool
+}
+
+func newCircuitBreaker(failureThreshold int, cooldown time.Duration) *circuitBreaker {
+	return &circuitBreaker{
+		state:            CircuitClosed,
+		failureThreshold: failureThreshold,
+		cooldown:         cooldown,
+	}
+}
+
+// Allow reports whether a request should be attempted: always in Closed,
+// never in Open (until the cooldown elapses, which flips it to HalfOpen),
+// and exactly once per cooldown in HalfOpen — the trialInFlight flag blocks
+// every caller but the first until RecordSuccess/RecordFailure resolves it.
+func (cb *circuitBreaker) Allow() bool {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	switch cb.state {
+	case CircuitOpen:
+		if time.Since(cb.openedAt) >= cb.cooldown {
+			cb.state = CircuitHalfOpen
+			cb.trialInFlight = true
+			return true
+		}
+		return false
+	case CircuitHalfOpen:
+		if cb.trialInFlight {
+			return false
+		}
+		cb.trialInFlight = true
+		return true
+	default:
+		return true
+	}
+}
+
+func (cb *circuitBreaker) RecordSuccess() {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	cb.state = CircuitClosed
+	cb.failureCount = 0
+	cb.trialInFlight = false
+}
+
+func (cb *circuitBreaker) RecordFailure() {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if cb.state == CircuitHalfOpen {
+		cb.state = CircuitOpen
+		cb.openedAt = time.Now()
+		cb.trialInFlight = false
+		return
+	}
+
+	cb.failureCount++
+	if cb.failureCount >= cb.failureThreshold {
+		cb.state = CircuitOpen
+		cb.openedAt = time.Now()
+	}
+}
+
+func (cb *circuitBreaker) snapshot() (CircuitState, int) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	return cb.state, cb.failureCount
+}
+
+// ProcessorHealth tracks a circuit breaker per processor, fed both by
+// periodic /payments/service-health probes and by real traffic outcomes, and
+// picks which processor the worker should use next.
+type ProcessorHealth struct {
+	urls     map[string]string
+	breakers map[string]*circuitBreaker
+
+	mu        sync.RWMutex
+	lastProbe map[string]time.Time
+}
+
+func newProcessorHealth() *ProcessorHealth {
+	ph := &ProcessorHealth{
+		urls: map[string]string{
+			"default":  "http://payment-processor-default:8080",
+			"fallback": "http://payment-processor-fallback:8080",
+		},
+		breakers: map[string]*circuitBreaker{
+			"default":  newCircuitBreaker(breakerFailureThreshold, breakerCooldown),
+			"fallback": newCircuitBreaker(breakerFailureThreshold, breakerCooldown),
+		},
+		lastProbe: make(map[string]time.Time),
+	}
+	go ph.probeLoop()
+	return ph
+}
+
+func (ph *ProcessorHealth) probeLoop() {
+	ticker := time.NewTicker(probeInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		for name, url := range ph.urls {
+			ph.probe(name, url)
+		}
+	}
+}
+
+func (ph *ProcessorHealth) probe(name, url string) {
+	resp, err := http.Get(url + "/payments/service-health")
+
+	ph.mu.Lock()
+	ph.lastProbe[name] = time.Now()
+	ph.mu.Unlock()
+
+	if err != nil {
+		ph.breakers[name].RecordFailure()
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		ph.breakers[name].RecordFailure()
+		return
+	}
+	ph.breakers[name].RecordSuccess()
+}
+
+// SelectProcessor picks the default processor unless its breaker is open, in
+// which case it skips straight to the fallback.
+func (ph *ProcessorHealth) SelectProcessor() (url string, isFallback bool) {
+	if ph.breakers["default"].Allow() {
+		return ph.urls["default"], false
+	}
+	return ph.urls["fallback"], true
+}
+
+// RecordResult feeds a real traffic outcome back into the named processor's
+// breaker, independent of the periodic health probes.
+func (ph *ProcessorHealth) RecordResult(processor string, success bool) {
+	cb := ph.breakers[processor]
+	if cb == nil {
+		return
+	}
+	if success {
+		cb.RecordSuccess()
+	} else {
+		cb.RecordFailure()
+	}
+}
+
+// ProcessorStatus is the per-processor view returned by GET /health.
+type ProcessorStatus struct {
+	Processor    string    `json:"processor"`
+	State        string    `json:"state"`
+	FailureCount int       `json:"failureCount"`
+	LastProbeAt  time.Time `json:"lastProbeAt"`
+}
+
+func (ph *ProcessorHealth) Status() []ProcessorStatus {
+	ph.mu.RLock()
+	defer ph.mu.RUnlock()
+
+	statuses := make([]ProcessorStatus, 0, len(ph.breakers))
+	for _, name := range []string{"default", "fallback"} {
+		state, failureCount := ph.breakers[name].snapshot()
+		statuses = append(statuses, ProcessorStatus{
+			Processor:    name,
+			State:        state.String(),
+			FailureCount: failureCount,
+			LastProbeAt:  ph.lastProbe[name],
+		})
+	}
+	return statuses
+}
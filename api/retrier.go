@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"math/rand"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	retrierBaseDelay    = 200 * time.Millisecond
+	retrierMaxDelay     = 30 * time.Second
+	retrierMaxAttempts  = 10
+	retrierPollInterval = 500 * time.Millisecond
+)
+
+// Retrier records failed payment attempts in payment_retries and requeues
+// them once their backoff window elapses, so a crash never loses a retry the
+// way recursing into the in-memory channel did.
+type Retrier struct {
+	db    *sqlx.DB
+	ct    *ControlTower
+	queue chan<- Payment
+	hub   *PaymentHub
+
+	baseDelay   time.Duration
+	maxDelay    time.Duration
+	maxAttempts int
+}
+
+func newRetrier(db *sqlx.DB, ct *ControlTower, queue chan<- Payment, hub *PaymentHub) *Retrier {
+	db.Exec(`
+		create table if not exists payment_retries (
+			correlation_id text primary key,
+			next_attempt_at timestamp not null,
+			attempt_count int not null default 0,
+			last_error text
+		)
+	`)
+	return &Retrier{
+		db:          db,
+		ct:          ct,
+		queue:       queue,
+		hub:         hub,
+		baseDelay:   retrierBaseDelay,
+		maxDelay:    retrierMaxDelay,
+		maxAttempts: retrierMaxAttempts,
+	}
+}
+
+// Schedule records a failed attempt and, unless maxAttempts has been
+// exhausted, computes the next backoff window for the payment.
+func (rt *Retrier) Schedule(payment Payment, cause error) {
+	var attemptCount int
+	err := rt.db.Get(&attemptCount, "select attempt_count from payment_retries where correlation_id = $1", payment.CorrelationID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		log.Println(err)
+	}
+	attemptCount++
+
+	if attemptCount > rt.maxAttempts {
+		rt.ct.Fail(payment.CorrelationID, cause.Error())
+		rt.db.Exec("delete from payment_retries where correlation_id = $1", payment.CorrelationID)
+		rt.hub.Publish(PaymentEvent{
+			Type:          eventFailed,
+			CorrelationID: payment.CorrelationID,
+			Timestamp:     time.Now(),
+			AttemptCount:  attemptCount,
+		})
+		return
+	}
+
+	nextAttemptAt := time.Now().Add(rt.backoff(attemptCount))
+	_, err = rt.db.Exec(`
+		insert into payment_retries (correlation_id, next_attempt_at, attempt_count, last_error)
+		values ($1, $2, $3, $4)
+		on conflict (correlation_id) do update set next_attempt_at = $2, attempt_count = $3, last_error = $4
+	`, payment.CorrelationID, nextAttemptAt, attemptCount, cause.Error())
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// backoff computes delay = min(baseDelay * 2^attempt, maxDelay) + rand(0, baseDelay).
+func (rt *Retrier) backoff(attempt int) time.Duration {
+	delay := rt.baseDelay * time.Duration(int64(1)<<uint(attempt))
+	if delay <= 0 || delay > rt.maxDelay {
+		delay = rt.maxDelay
+	}
+	return delay + time.Duration(rand.Int63n(int64(rt.baseDelay)))
+}
+
+// Run polls payment_retries for due rows and pushes them back onto the
+// worker queue, until ctx is cancelled.
+func (rt *Retrier) Run(ctx context.Context) {
+	ticker := time.NewTicker(retrierPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			rt.requeueDue()
+		}
+	}
+}
+
+func (rt *Retrier) requeueDue() {
+	var due []Payment
+	err := rt.db.Select(&due, `
+		select a.correlation_id, a.amount
+		from payment_retries r
+		join payment_attempts a on a.correlation_id = r.correlation_id
+		where r.next_attempt_at <= now()
+	`)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	for _, payment := range due {
+		// Keep the row (and its attempt_count) so the next Schedule keeps
+		// counting towards maxAttempts; only take it out of the due set.
+		_, err := rt.db.Exec("update payment_retries set next_attempt_at = 'infinity' where correlation_id = $1", payment.CorrelationID)
+		if err != nil {
+			log.Println(err)
+		}
+		rt.queue <- payment
+	}
+}